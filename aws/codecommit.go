@@ -1,6 +1,9 @@
 package aws
 
 import (
+	"fmt"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -16,7 +19,25 @@ import (
 	"github.com/hashicorp/go-multierror"
 )
 
+// defaultCodeCommitMaxConcurrentNukes is the number of CodeCommit repositories or approval rule
+// templates we delete at the same time when the user hasn't configured MaxConcurrentNukes, so
+// that we benefit from concurrency without tripping AWS API rate limits.
+const defaultCodeCommitMaxConcurrentNukes = 10
+
+// codeCommitMaxConcurrentNukes returns the configured concurrency limit, falling back to
+// defaultCodeCommitMaxConcurrentNukes when the user hasn't set one.
+func codeCommitMaxConcurrentNukes(configured int) int {
+	if configured <= 0 {
+		return defaultCodeCommitMaxConcurrentNukes
+	}
+	return configured
+}
+
 func getAllCodeCommitRepositories(session *session.Session, excludeAfter time.Time, configObj config.Config) ([]*string, error) {
+	if err := validateCodeCommitRepositoryTagRules(configObj); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
 	svc := codecommit.New(session)
 
 	allRepositories := []*string{}
@@ -42,7 +63,15 @@ func getAllCodeCommitRepositories(session *session.Session, excludeAfter time.Ti
 		if err != nil {
 			return nil, errors.WithStackTrace(err)
 		}
-		if shouldIncludeCodeCommitRepository(repository.RepositoryMetadata, excludeAfter, configObj) {
+
+		tagsOutput, err := svc.ListTagsForResource(&codecommit.ListTagsForResourceInput{
+			ResourceArn: repository.RepositoryMetadata.Arn,
+		})
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+
+		if shouldIncludeCodeCommitRepository(repository.RepositoryMetadata, tagsOutput.Tags, excludeAfter, configObj) {
 			allRepositories = append(allRepositories, repositoryName)
 		}
 	}
@@ -50,7 +79,7 @@ func getAllCodeCommitRepositories(session *session.Session, excludeAfter time.Ti
 	return allRepositories, errors.WithStackTrace(err)
 }
 
-func shouldIncludeCodeCommitRepository(repository *codecommit.RepositoryMetadata, excludeAfter time.Time, configObj config.Config) bool {
+func shouldIncludeCodeCommitRepository(repository *codecommit.RepositoryMetadata, tags map[string]*string, excludeAfter time.Time, configObj config.Config) bool {
 	if repository == nil {
 		return false
 	}
@@ -59,11 +88,90 @@ func shouldIncludeCodeCommitRepository(repository *codecommit.RepositoryMetadata
 		return false
 	}
 
-	return config.ShouldInclude(
+	if !config.ShouldInclude(
 		aws.StringValue(repository.RepositoryName),
 		configObj.CodeCommitRepository.IncludeRule.NamesRegExp,
 		configObj.CodeCommitRepository.ExcludeRule.NamesRegExp,
-	)
+	) {
+		return false
+	}
+
+	if !config.ShouldInclude(
+		aws.StringValue(repository.KmsKeyId),
+		configObj.CodeCommitRepository.IncludeRule.KMSKeyArnRegExp,
+		configObj.CodeCommitRepository.ExcludeRule.KMSKeyArnRegExp,
+	) {
+		return false
+	}
+
+	if !shouldIncludeCodeCommitRepositoryBasedOnTags(tags, configObj) {
+		return false
+	}
+
+	return true
+}
+
+// validateCodeCommitRepositoryTagRules compiles every tag-key pattern configured for
+// CodeCommitRepository up front, so that a malformed pattern fails the entire run immediately
+// instead of being silently skipped while repositories are evaluated for nuking. This matters
+// most for ExcludeRule.Tags, which is relied on to protect repositories (e.g. Environment=prod)
+// from being swept - silently ignoring a bad pattern there would nuke the very repos it was
+// meant to protect.
+func validateCodeCommitRepositoryTagRules(configObj config.Config) error {
+	var multiErr *multierror.Error
+
+	for keyPattern := range configObj.CodeCommitRepository.ExcludeRule.Tags {
+		if _, err := regexp.Compile(keyPattern); err != nil {
+			multiErr = multierror.Append(multiErr, fmt.Errorf("invalid tag key regex %q in CodeCommitRepository.ExcludeRule.Tags: %w", keyPattern, err))
+		}
+	}
+	for keyPattern := range configObj.CodeCommitRepository.IncludeRule.Tags {
+		if _, err := regexp.Compile(keyPattern); err != nil {
+			multiErr = multierror.Append(multiErr, fmt.Errorf("invalid tag key regex %q in CodeCommitRepository.IncludeRule.Tags: %w", keyPattern, err))
+		}
+	}
+
+	return multiErr.ErrorOrNil()
+}
+
+// shouldIncludeCodeCommitRepositoryBasedOnTags evaluates a repository's tags against the
+// configured include/exclude tag rules, where each rule pairs a tag key regex with a tag value
+// regex. A repository is excluded if any of its tags match an exclude rule, and, when include
+// rules are configured, is only included if at least one of its tags matches one of them.
+//
+// Callers must have already run validateCodeCommitRepositoryTagRules against configObj, so every
+// key pattern here is guaranteed to compile.
+func shouldIncludeCodeCommitRepositoryBasedOnTags(tags map[string]*string, configObj config.Config) bool {
+	excludeRules := configObj.CodeCommitRepository.ExcludeRule.Tags
+	includeRules := configObj.CodeCommitRepository.IncludeRule.Tags
+
+	if len(excludeRules) == 0 && len(includeRules) == 0 {
+		return true
+	}
+
+	for keyPattern, valueExpr := range excludeRules {
+		keyRE := regexp.MustCompile(keyPattern)
+		for key, value := range tags {
+			if keyRE.MatchString(key) && valueExpr.RE.MatchString(aws.StringValue(value)) {
+				return false
+			}
+		}
+	}
+
+	if len(includeRules) == 0 {
+		return true
+	}
+
+	for keyPattern, valueExpr := range includeRules {
+		keyRE := regexp.MustCompile(keyPattern)
+		for key, value := range tags {
+			if keyRE.MatchString(key) && valueExpr.RE.MatchString(aws.StringValue(value)) {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // deleteCodeCommitRepository is a helper method that deletes the given codecommit repository.
@@ -80,7 +188,7 @@ func deleteCodeCommitRepository(svc *codecommit.CodeCommit, repositoryName *stri
 	return nil
 }
 
-func nukeAllCodeCommitRepositories(session *session.Session, identifiers []*string) error {
+func nukeAllCodeCommitRepositories(session *session.Session, identifiers []*string, configObj config.Config) error {
 	region := aws.StringValue(session.Config.Region)
 
 	svc := codecommit.New(session)
@@ -101,18 +209,38 @@ func nukeAllCodeCommitRepositories(session *session.Session, identifiers []*stri
 
 	logging.Logger.Debugf("Deleting CodeCommit Repositories in region %s", region)
 
-	var multiErr *multierror.Error
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(identifiers))
+	semaphore := make(chan struct{}, codeCommitMaxConcurrentNukes(configObj.CodeCommitRepository.MaxConcurrentNukes))
+
 	for _, repositoryName := range identifiers {
-		if err := deleteCodeCommitRepository(svc, repositoryName); err != nil {
-			telemetry.TrackEvent(commonTelemetry.EventContext{
-				EventName: "Error Nuking CodeCommit Repository",
-			}, map[string]interface{}{
-				"region": region,
-			})
-			logging.Logger.Errorf("[Failed] %s", err)
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(repositoryName *string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			err := deleteCodeCommitRepository(svc, repositoryName)
+			if err != nil {
+				telemetry.TrackEvent(commonTelemetry.EventContext{
+					EventName: "Error Nuking CodeCommit Repository",
+				}, map[string]interface{}{
+					"region": region,
+				})
+				logging.Logger.Errorf("[Failed] %s", err)
+			} else {
+				logging.Logger.Infof("[OK] CodeCommit Repository %s was deleted in %s", aws.StringValue(repositoryName), region)
+			}
+			errChan <- err
+		}(repositoryName)
+	}
+	wg.Wait()
+	close(errChan)
+
+	var multiErr *multierror.Error
+	for err := range errChan {
+		if err != nil {
 			multiErr = multierror.Append(multiErr, err)
-		} else {
-			logging.Logger.Infof("[OK] CodeCommit Repository %s was deleted in %s", aws.StringValue(repositoryName), region)
 		}
 	}
 
@@ -169,8 +297,15 @@ func shouldIncludeCodeCommitApprovalRuleTemplate(template *codecommit.ApprovalRu
 	)
 }
 
-// deleteCodeCommitApprovalRuleTemplate is a helper method that deletes the given codecommit approval rule template.
+// deleteCodeCommitApprovalRuleTemplate is a helper method that disassociates the given codecommit
+// approval rule template from any repositories it is still associated with, then deletes it. AWS
+// will not let a template be deleted while it has associated repositories, so we must clear those
+// associations first.
 func deleteCodeCommitApprovalRuleTemplate(svc *codecommit.CodeCommit, templateName *string) error {
+	if err := disassociateCodeCommitApprovalRuleTemplateFromRepositories(svc, templateName); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
 	input := &codecommit.DeleteApprovalRuleTemplateInput{
 		ApprovalRuleTemplateName: templateName,
 	}
@@ -183,7 +318,57 @@ func deleteCodeCommitApprovalRuleTemplate(svc *codecommit.CodeCommit, templateNa
 	return nil
 }
 
-func nukeAllCodeCommitApprovalRuleTemplates(session *session.Session, identifiers []*string) error {
+// codeCommitBatchDisassociateRepositoriesLimit is the maximum number of repository names the
+// BatchDisassociateApprovalRuleTemplateFromRepositories API accepts in a single call.
+const codeCommitBatchDisassociateRepositoriesLimit = 100
+
+// disassociateCodeCommitApprovalRuleTemplateFromRepositories looks up every repository the given
+// approval rule template is associated with and disassociates it from all of them.
+func disassociateCodeCommitApprovalRuleTemplateFromRepositories(svc *codecommit.CodeCommit, templateName *string) error {
+	repositoryNames := []*string{}
+	err := svc.ListRepositoriesForApprovalRuleTemplatePages(
+		&codecommit.ListRepositoriesForApprovalRuleTemplateInput{
+			ApprovalRuleTemplateName: templateName,
+		},
+		func(page *codecommit.ListRepositoriesForApprovalRuleTemplateOutput, lastPage bool) bool {
+			repositoryNames = append(repositoryNames, page.RepositoryNames...)
+			return !lastPage
+		},
+	)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	var multiErr *multierror.Error
+	for start := 0; start < len(repositoryNames); start += codeCommitBatchDisassociateRepositoriesLimit {
+		end := start + codeCommitBatchDisassociateRepositoriesLimit
+		if end > len(repositoryNames) {
+			end = len(repositoryNames)
+		}
+
+		output, err := svc.BatchDisassociateApprovalRuleTemplateFromRepositories(&codecommit.BatchDisassociateApprovalRuleTemplateFromRepositoriesInput{
+			ApprovalRuleTemplateName: templateName,
+			RepositoryNames:          repositoryNames[start:end],
+		})
+		if err != nil {
+			multiErr = multierror.Append(multiErr, errors.WithStackTrace(err))
+			continue
+		}
+
+		for _, batchErr := range output.Errors {
+			multiErr = multierror.Append(multiErr, fmt.Errorf(
+				"failed to disassociate approval rule template %s from repository %s: %s",
+				aws.StringValue(templateName),
+				aws.StringValue(batchErr.RepositoryName),
+				aws.StringValue(batchErr.ErrorMessage),
+			))
+		}
+	}
+
+	return multiErr.ErrorOrNil()
+}
+
+func nukeAllCodeCommitApprovalRuleTemplates(session *session.Session, identifiers []*string, configObj config.Config) error {
 	region := aws.StringValue(session.Config.Region)
 
 	svc := codecommit.New(session)
@@ -204,18 +389,38 @@ func nukeAllCodeCommitApprovalRuleTemplates(session *session.Session, identifier
 
 	logging.Logger.Debugf("Deleting CodeCommit Approval Rule Templates in region %s", region)
 
-	var multiErr *multierror.Error
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(identifiers))
+	semaphore := make(chan struct{}, codeCommitMaxConcurrentNukes(configObj.CodeCommitApprovalRuleTemplate.MaxConcurrentNukes))
+
 	for _, templateName := range identifiers {
-		if err := deleteCodeCommitApprovalRuleTemplate(svc, templateName); err != nil {
-			telemetry.TrackEvent(commonTelemetry.EventContext{
-				EventName: "Error Nuking CodeCommit Approval Rule Templates",
-			}, map[string]interface{}{
-				"region": region,
-			})
-			logging.Logger.Errorf("[Failed] %s", err)
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(templateName *string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			err := deleteCodeCommitApprovalRuleTemplate(svc, templateName)
+			if err != nil {
+				telemetry.TrackEvent(commonTelemetry.EventContext{
+					EventName: "Error Nuking CodeCommit Approval Rule Templates",
+				}, map[string]interface{}{
+					"region": region,
+				})
+				logging.Logger.Errorf("[Failed] %s", err)
+			} else {
+				logging.Logger.Infof("[OK] CodeCommit Approval Rule Template %s was deleted in %s", aws.StringValue(templateName), region)
+			}
+			errChan <- err
+		}(templateName)
+	}
+	wg.Wait()
+	close(errChan)
+
+	var multiErr *multierror.Error
+	for err := range errChan {
+		if err != nil {
 			multiErr = multierror.Append(multiErr, err)
-		} else {
-			logging.Logger.Infof("[OK] CodeCommit Approval Rule Template %s was deleted in %s", aws.StringValue(templateName), region)
 		}
 	}
 
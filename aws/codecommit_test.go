@@ -10,6 +10,7 @@ import (
 	"github.com/gruntwork-io/cloud-nuke/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"regexp"
 	"testing"
 	"time"
 )
@@ -60,6 +61,118 @@ func TestTimeFilterExclusionNewlyCreatedCodeCommitRepository(t *testing.T) {
 	assert.NotContains(t, aws.StringValueSlice(repoNamesOlder), aws.StringValue(repoName))
 }
 
+func TestTagFilterExclusionCodeCommitRepository(t *testing.T) {
+	telemetry.InitTelemetry("cloud-nuke", "", "")
+	t.Parallel()
+
+	region, err := getRandomRegion()
+	require.NoError(t, err)
+
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	require.NoError(t, err)
+	svc := codecommit.New(session)
+
+	repoName := createCodeCommitRepository(t, svc)
+	defer deleteCodeCommitRepositoryInTest(t, svc, repoName, true)
+
+	repo, err := svc.GetRepository(&codecommit.GetRepositoryInput{RepositoryName: repoName})
+	require.NoError(t, err)
+
+	_, err = svc.TagResource(&codecommit.TagResourceInput{
+		ResourceArn: repo.RepositoryMetadata.Arn,
+		Tags:        map[string]*string{"Environment": aws.String("prod")},
+	})
+	require.NoError(t, err)
+
+	excludeConfig := config.Config{
+		CodeCommitRepository: config.CodeCommitRepository{
+			ExcludeRule: config.CodeCommitRepositoryFilterRule{
+				Tags: map[string]config.Expression{
+					"Environment": {RE: *regexp.MustCompile("^prod$")},
+				},
+			},
+		},
+	}
+
+	repoNames, err := getAllCodeCommitRepositories(session, time.Now(), excludeConfig)
+	require.NoError(t, err)
+	assert.NotContains(t, aws.StringValueSlice(repoNames), aws.StringValue(repoName))
+}
+
+func TestInvalidTagKeyRegexFailsCodeCommitRepositoryListing(t *testing.T) {
+	telemetry.InitTelemetry("cloud-nuke", "", "")
+	t.Parallel()
+
+	region, err := getRandomRegion()
+	require.NoError(t, err)
+
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	require.NoError(t, err)
+
+	// A malformed tag key regex in ExcludeRule.Tags must fail the whole listing call up front,
+	// rather than being silently skipped while leaving the repo it was meant to protect exposed.
+	invalidConfig := config.Config{
+		CodeCommitRepository: config.CodeCommitRepository{
+			ExcludeRule: config.CodeCommitRepositoryFilterRule{
+				Tags: map[string]config.Expression{
+					"Environment(": {RE: *regexp.MustCompile("^prod$")},
+				},
+			},
+		},
+	}
+
+	_, err = getAllCodeCommitRepositories(session, time.Now(), invalidConfig)
+	require.Error(t, err)
+}
+
+func TestKMSKeyFilterCodeCommitRepository(t *testing.T) {
+	telemetry.InitTelemetry("cloud-nuke", "", "")
+	t.Parallel()
+
+	region, err := getRandomRegion()
+	require.NoError(t, err)
+
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	require.NoError(t, err)
+	svc := codecommit.New(session)
+
+	repoName := createCodeCommitRepository(t, svc)
+	defer deleteCodeCommitRepositoryInTest(t, svc, repoName, true)
+
+	repo, err := svc.GetRepository(&codecommit.GetRepositoryInput{RepositoryName: repoName})
+	require.NoError(t, err)
+	require.NotNil(t, repo.RepositoryMetadata.KmsKeyId)
+
+	// Assert the repository is excluded when its KMS key ID matches an exclude rule.
+	excludeConfig := config.Config{
+		CodeCommitRepository: config.CodeCommitRepository{
+			ExcludeRule: config.CodeCommitRepositoryFilterRule{
+				KMSKeyArnRegExp: []config.Expression{
+					{RE: *regexp.MustCompile(regexp.QuoteMeta(aws.StringValue(repo.RepositoryMetadata.KmsKeyId)))},
+				},
+			},
+		},
+	}
+	excludedNames, err := getAllCodeCommitRepositories(session, time.Now(), excludeConfig)
+	require.NoError(t, err)
+	assert.NotContains(t, aws.StringValueSlice(excludedNames), aws.StringValue(repoName))
+
+	// Assert the repository is excluded when an include rule is configured that does not match
+	// its KMS key ID.
+	includeConfig := config.Config{
+		CodeCommitRepository: config.CodeCommitRepository{
+			IncludeRule: config.CodeCommitRepositoryFilterRule{
+				KMSKeyArnRegExp: []config.Expression{
+					{RE: *regexp.MustCompile("^arn:aws:kms:.*:key/does-not-exist$")},
+				},
+			},
+		},
+	}
+	includedNames, err := getAllCodeCommitRepositories(session, time.Now(), includeConfig)
+	require.NoError(t, err)
+	assert.NotContains(t, aws.StringValueSlice(includedNames), aws.StringValue(repoName))
+}
+
 func TestNukeCodeCommitRepositoryOne(t *testing.T) {
 	telemetry.InitTelemetry("cloud-nuke", "", "")
 	t.Parallel()
@@ -78,7 +191,7 @@ func TestNukeCodeCommitRepositoryOne(t *testing.T) {
 
 	require.NoError(
 		t,
-		nukeAllCodeCommitRepositories(session, identifiers),
+		nukeAllCodeCommitRepositories(session, identifiers, config.Config{}),
 	)
 
 	// Make sure the CodeCommit Repository is deleted.
@@ -106,7 +219,7 @@ func TestNukeCodeCommitRepositoriesMoreThanOne(t *testing.T) {
 
 	require.NoError(
 		t,
-		nukeAllCodeCommitRepositories(session, repoNames),
+		nukeAllCodeCommitRepositories(session, repoNames, config.Config{}),
 	)
 
 	// Make sure the CodeCommit Repositories are deleted.
@@ -177,13 +290,52 @@ func TestNukeCodeCommitApprovalRuleTemplateOne(t *testing.T) {
 
 	require.NoError(
 		t,
-		nukeAllCodeCommitApprovalRuleTemplates(session, identifiers),
+		nukeAllCodeCommitApprovalRuleTemplates(session, identifiers, config.Config{}),
 	)
 
 	// Make sure the CodeCommit Approval Rule Template is deleted.
 	assertCodeCommitApprovalRuleTemplatesDeleted(t, svc, identifiers)
 }
 
+func TestNukeCodeCommitApprovalRuleTemplateAssociatedWithRepository(t *testing.T) {
+	telemetry.InitTelemetry("cloud-nuke", "", "")
+	t.Parallel()
+
+	region, err := getRandomRegion()
+	require.NoError(t, err)
+
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	require.NoError(t, err)
+	svc := codecommit.New(session)
+
+	// We ignore errors in the delete calls here, because they are intended to be a stop gap in case there is a bug in nuke.
+	templateName := createCodeCommitApprovalRuleTemplate(t, svc)
+	defer deleteCodeCommitApprovalRuleTemplateInTest(t, svc, templateName, false)
+
+	repoName := createCodeCommitRepository(t, svc)
+	defer deleteCodeCommitRepositoryInTest(t, svc, repoName, false)
+
+	_, err = svc.AssociateApprovalRuleTemplateWithRepository(&codecommit.AssociateApprovalRuleTemplateWithRepositoryInput{
+		ApprovalRuleTemplateName: templateName,
+		RepositoryName:           repoName,
+	})
+	require.NoError(t, err)
+
+	identifiers := []*string{templateName}
+	require.NoError(
+		t,
+		nukeAllCodeCommitApprovalRuleTemplates(session, identifiers, config.Config{}),
+	)
+
+	// Make sure the CodeCommit Approval Rule Template is deleted, and that the repository it was
+	// associated with was left intact.
+	assertCodeCommitApprovalRuleTemplatesDeleted(t, svc, identifiers)
+
+	repo, err := svc.GetRepository(&codecommit.GetRepositoryInput{RepositoryName: repoName})
+	require.NoError(t, err)
+	assert.NotNil(t, repo.RepositoryMetadata)
+}
+
 func TestNukeCodeCommitApprovalRuleTemplatesMoreThanOne(t *testing.T) {
 	telemetry.InitTelemetry("cloud-nuke", "", "")
 	t.Parallel()
@@ -205,7 +357,7 @@ func TestNukeCodeCommitApprovalRuleTemplatesMoreThanOne(t *testing.T) {
 
 	require.NoError(
 		t,
-		nukeAllCodeCommitApprovalRuleTemplates(session, templateNames),
+		nukeAllCodeCommitApprovalRuleTemplates(session, templateNames, config.Config{}),
 	)
 
 	// Make sure the CodeCommit Approval Rule Templates are deleted.
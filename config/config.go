@@ -0,0 +1,83 @@
+package config
+
+import "regexp"
+
+// Config is the parsed representation of the user-supplied YAML config file that controls which
+// resources cloud-nuke considers when listing and nuking resources in each supported AWS service.
+type Config struct {
+	CodeCommitRepository           CodeCommitRepository
+	CodeCommitApprovalRuleTemplate ResourceType
+}
+
+// ResourceType is the generic include/exclude configuration shared by most resource types: a
+// name-matching filter rule plus a cap on how many identifiers are nuked in a single batch.
+type ResourceType struct {
+	IncludeRule FilterRule
+	ExcludeRule FilterRule
+
+	// MaxBatchSize caps how many identifiers the caller passes to a single nuke call.
+	MaxBatchSize int
+
+	// MaxConcurrentNukes caps how many identifiers are nuked concurrently within a single nuke
+	// call. A value <= 0 means the nuker falls back to its own default.
+	MaxConcurrentNukes int
+}
+
+// FilterRule is the set of matchers a resource is checked against.
+type FilterRule struct {
+	NamesRegExp []Expression
+	Tags        map[string]Expression
+}
+
+// CodeCommitRepository is the include/exclude configuration for CodeCommit repositories. It
+// mirrors ResourceType, but its filter rule also supports matching on the KMS key used to encrypt
+// the repository, which is specific to this resource.
+type CodeCommitRepository struct {
+	IncludeRule CodeCommitRepositoryFilterRule
+	ExcludeRule CodeCommitRepositoryFilterRule
+
+	MaxBatchSize       int
+	MaxConcurrentNukes int
+}
+
+// CodeCommitRepositoryFilterRule extends FilterRule with a KMS key ARN matcher, so that
+// repositories encrypted with a particular customer-managed KMS key can be protected from nuking.
+type CodeCommitRepositoryFilterRule struct {
+	NamesRegExp     []Expression
+	KMSKeyArnRegExp []Expression
+	Tags            map[string]Expression
+}
+
+// Expression wraps a compiled regular expression so that it can be used directly in include and
+// exclude rules.
+type Expression struct {
+	RE regexp.Regexp
+}
+
+// ShouldInclude returns true if the given value should be included, based on the provided include
+// and exclude expressions. An empty include list is treated as "include everything not
+// excluded". Exclude rules always take precedence over include rules.
+func ShouldInclude(value string, includeREs []Expression, excludeREs []Expression) bool {
+	if len(includeREs) == 0 && len(excludeREs) == 0 {
+		return true
+	}
+
+	if len(excludeREs) > 0 && matchesAny(value, excludeREs) {
+		return false
+	}
+
+	if len(includeREs) == 0 {
+		return true
+	}
+
+	return matchesAny(value, includeREs)
+}
+
+func matchesAny(value string, expressions []Expression) bool {
+	for _, expression := range expressions {
+		if expression.RE.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}